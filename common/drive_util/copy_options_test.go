@@ -0,0 +1,60 @@
+package drive_util
+
+import (
+	"go-drive/common/task"
+	"testing"
+	"time"
+)
+
+func TestCopyOptionsWorkers(t *testing.T) {
+	cases := []struct {
+		workers int
+		want    int
+	}{
+		{0, defaultCopyWorkers},
+		{-1, defaultCopyWorkers},
+		{8, 8},
+	}
+	for _, c := range cases {
+		if got := (CopyOptions{Workers: c.workers}).workers(); got != c.want {
+			t.Errorf("CopyOptions{Workers: %d}.workers() = %d, want %d", c.workers, got, c.want)
+		}
+	}
+}
+
+func TestCopyOptionsRetryBackoff(t *testing.T) {
+	cases := []struct {
+		backoff time.Duration
+		want    time.Duration
+	}{
+		{0, defaultCopyRetryBackoff},
+		{-time.Second, defaultCopyRetryBackoff},
+		{2 * time.Second, 2 * time.Second},
+	}
+	for _, c := range cases {
+		if got := (CopyOptions{RetryBackoff: c.backoff}).retryBackoff(); got != c.want {
+			t.Errorf("CopyOptions{RetryBackoff: %v}.retryBackoff() = %v, want %v", c.backoff, got, c.want)
+		}
+	}
+}
+
+func TestDefaultCopyOptions(t *testing.T) {
+	opts := DefaultCopyOptions()
+	if opts.workers() != defaultCopyWorkers {
+		t.Errorf("DefaultCopyOptions().workers() = %d, want %d", opts.workers(), defaultCopyWorkers)
+	}
+	if opts.RetryCount != 2 {
+		t.Errorf("DefaultCopyOptions().RetryCount = %d, want 2", opts.RetryCount)
+	}
+}
+
+func TestTimeoutCtxCancel(t *testing.T) {
+	ctx := &timeoutCtx{TaskCtx: task.DummyContext()}
+	if ctx.Canceled() {
+		t.Fatal("expected not canceled before cancel()")
+	}
+	ctx.cancel()
+	if !ctx.Canceled() {
+		t.Fatal("expected canceled after cancel()")
+	}
+}
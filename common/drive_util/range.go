@@ -0,0 +1,219 @@
+package drive_util
+
+import (
+	"fmt"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+type httpRange struct {
+	start, length int64
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+func invalidRangeError() error {
+	return err.NewNotAllowedMessageError(i18n.T("util.invalid_range"))
+}
+
+// parseByteRanges parses a Range header's byte-ranges against a resource of
+// the given size, per RFC 7233 Section 2.1. A nil, nil result means "no
+// Range header" (serve the whole thing); a non-nil error means the header
+// was present but malformed or unsatisfiable.
+func parseByteRanges(header string, size int64) ([]httpRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return nil, invalidRangeError()
+	}
+
+	var ranges []httpRange
+	for _, part := range strings.Split(header[len(prefix):], ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		dash := strings.IndexByte(part, '-')
+		if dash < 0 {
+			return nil, invalidRangeError()
+		}
+		startStr, endStr := part[:dash], part[dash+1:]
+
+		var r httpRange
+		if startStr == "" {
+			// suffix range: the last N bytes
+			n, e := strconv.ParseInt(endStr, 10, 64)
+			if e != nil || n <= 0 {
+				return nil, invalidRangeError()
+			}
+			if n > size {
+				n = size
+			}
+			r = httpRange{start: size - n, length: n}
+		} else {
+			start, e := strconv.ParseInt(startStr, 10, 64)
+			if e != nil || start >= size {
+				return nil, invalidRangeError()
+			}
+			end := size - 1
+			if endStr != "" {
+				end, e = strconv.ParseInt(endStr, 10, 64)
+				if e != nil || end < start {
+					return nil, invalidRangeError()
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			r = httpRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, invalidRangeError()
+	}
+	return ranges, nil
+}
+
+// openRange opens a reader for the given range of content, preferring
+// types.IRangeContent's server-side byte-range support and falling back to
+// discarding leading bytes of a full read when the backend doesn't have it.
+func openRange(content types.IContent, ra httpRange) (io.ReadCloser, error) {
+	if rc, ok := content.(types.IRangeContent); ok {
+		return rc.GetRangeReader(ra.start, ra.length)
+	}
+	reader, e := content.GetReader()
+	if e != nil {
+		return nil, e
+	}
+	if _, e := io.CopyN(ioutil.Discard, reader, ra.start); e != nil {
+		_ = reader.Close()
+		return nil, e
+	}
+	return reader, nil
+}
+
+// ServeRangeContent writes content to w, honoring a Range request header
+// when present (single range as a 206 Partial Content, several ranges as a
+// multipart/byteranges 206), and falls back to a plain 200 otherwise. It
+// uses types.IRangeContent when content implements it, so the whole file
+// need not be read to serve a small range.
+func ServeRangeContent(content types.IContent, w http.ResponseWriter, req *http.Request) error {
+	size := content.Size()
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	ranges, e := parseByteRanges(req.Header.Get("Range"), size)
+	if e != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return nil
+	}
+
+	if len(ranges) == 0 {
+		reader, e := content.GetReader()
+		if e != nil {
+			return e
+		}
+		defer func() { _ = reader.Close() }()
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		if req.Method == http.MethodHead {
+			return nil
+		}
+		_, e = io.Copy(w, reader)
+		return e
+	}
+
+	if len(ranges) == 1 {
+		ra := ranges[0]
+		reader, e := openRange(content, ra)
+		if e != nil {
+			return e
+		}
+		defer func() { _ = reader.Close() }()
+		w.Header().Set("Content-Range", ra.contentRange(size))
+		w.Header().Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if req.Method == http.MethodHead {
+			return nil
+		}
+		_, e = io.CopyN(w, reader, ra.length)
+		return e
+	}
+
+	return serveMultipartRanges(content, w, req, ranges, size)
+}
+
+// ServeRangeContentFromReader is ServeRangeContent for content that is
+// neither an io.ReadSeeker nor a types.IRangeContent: reader must be a
+// fresh, just-opened content.GetReader(), which is read from directly for a
+// single range instead of opening a second one. A multi-range request still
+// falls back to ServeRangeContent's own reopening, since a single
+// forward-only reader can't be rewound to serve more than one range.
+func ServeRangeContentFromReader(content types.IContent, reader io.ReadCloser, w http.ResponseWriter, req *http.Request) error {
+	size := content.Size()
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	ranges, e := parseByteRanges(req.Header.Get("Range"), size)
+	if e != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return nil
+	}
+	if len(ranges) != 1 {
+		_ = reader.Close()
+		return ServeRangeContent(content, w, req)
+	}
+
+	ra := ranges[0]
+	if _, e := io.CopyN(ioutil.Discard, reader, ra.start); e != nil {
+		return e
+	}
+	w.Header().Set("Content-Range", ra.contentRange(size))
+	w.Header().Set("Content-Length", strconv.FormatInt(ra.length, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	if req.Method == http.MethodHead {
+		return nil
+	}
+	_, e = io.CopyN(w, reader, ra.length)
+	return e
+}
+
+func serveMultipartRanges(content types.IContent, w http.ResponseWriter, req *http.Request, ranges []httpRange, size int64) error {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusPartialContent)
+	if req.Method == http.MethodHead {
+		return nil
+	}
+
+	for _, ra := range ranges {
+		part, e := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Range": {ra.contentRange(size)},
+		})
+		if e != nil {
+			return e
+		}
+		reader, e := openRange(content, ra)
+		if e != nil {
+			return e
+		}
+		_, e = io.CopyN(part, reader, ra.length)
+		_ = reader.Close()
+		if e != nil {
+			return e
+		}
+	}
+	return mw.Close()
+}
@@ -0,0 +1,84 @@
+package drive_util
+
+import "testing"
+
+func TestParseByteRangesNone(t *testing.T) {
+	ranges, e := parseByteRanges("", 100)
+	if e != nil || ranges != nil {
+		t.Fatalf("parseByteRanges(\"\", 100) = %v, %v; want nil, nil", ranges, e)
+	}
+}
+
+func TestParseByteRangesBasic(t *testing.T) {
+	ranges, e := parseByteRanges("bytes=0-99", 200)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if len(ranges) != 1 || ranges[0].start != 0 || ranges[0].length != 100 {
+		t.Fatalf("got %+v, want [{0 100}]", ranges)
+	}
+}
+
+func TestParseByteRangesSuffix(t *testing.T) {
+	ranges, e := parseByteRanges("bytes=-500", 1000)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if len(ranges) != 1 || ranges[0].start != 500 || ranges[0].length != 500 {
+		t.Fatalf("got %+v, want [{500 500}]", ranges)
+	}
+}
+
+func TestParseByteRangesSuffixLargerThanSize(t *testing.T) {
+	ranges, e := parseByteRanges("bytes=-5000", 1000)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if len(ranges) != 1 || ranges[0].start != 0 || ranges[0].length != 1000 {
+		t.Fatalf("got %+v, want [{0 1000}]", ranges)
+	}
+}
+
+func TestParseByteRangesOpenEnded(t *testing.T) {
+	ranges, e := parseByteRanges("bytes=500-", 1000)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if len(ranges) != 1 || ranges[0].start != 500 || ranges[0].length != 500 {
+		t.Fatalf("got %+v, want [{500 500}]", ranges)
+	}
+}
+
+func TestParseByteRangesMultiple(t *testing.T) {
+	ranges, e := parseByteRanges("bytes=0-99,200-299", 1000)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if len(ranges) != 2 {
+		t.Fatalf("got %+v, want 2 ranges", ranges)
+	}
+}
+
+func TestParseByteRangesInvalid(t *testing.T) {
+	cases := []string{
+		"foo=0-99",
+		"bytes=",
+		"bytes=abc-99",
+		"bytes=100-50",
+		"bytes=-0",
+		"bytes=1000-2000",
+	}
+	for _, header := range cases {
+		if _, e := parseByteRanges(header, 1000); e == nil {
+			t.Errorf("parseByteRanges(%q, 1000) expected error, got nil", header)
+		}
+	}
+}
+
+func TestHttpRangeContentRange(t *testing.T) {
+	r := httpRange{start: 10, length: 90}
+	want := "bytes 10-99/1000"
+	if got := r.contentRange(1000); got != want {
+		t.Errorf("contentRange() = %q, want %q", got, want)
+	}
+}
@@ -1,6 +1,7 @@
 package drive_util
 
 import (
+	"errors"
 	"go-drive/common/errors"
 	"go-drive/common/i18n"
 	"go-drive/common/task"
@@ -14,6 +15,9 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 func GetIEntry(entry types.IEntry, test func(iEntry types.IEntry) bool) types.IEntry {
@@ -36,6 +40,12 @@ func GetIEntry(entry types.IEntry, test func(iEntry types.IEntry) bool) types.IE
 	return entry
 }
 
+// ctxProgressMu serializes calls into a shared types.TaskCtx's Progress/Total
+// methods. CopyAll now drives several of these concurrently (one per
+// in-flight file copy, plus the tree walk), and TaskCtx implementations
+// aren't expected to be safe for concurrent use on their own.
+var ctxProgressMu sync.Mutex
+
 func Copy(dst io.Writer, src io.Reader, ctx types.TaskCtx) (written int64, err error) {
 	buf := make([]byte, 32*1024)
 	for {
@@ -50,7 +60,9 @@ func Copy(dst io.Writer, src io.Reader, ctx types.TaskCtx) (written int64, err e
 			break
 		}
 		written += w
+		ctxProgressMu.Lock()
 		ctx.Progress(w, false)
+		ctxProgressMu.Unlock()
 	}
 	return
 }
@@ -92,6 +104,17 @@ func CopyIContentToTempFile(content types.IContent, ctx types.TaskCtx, tempDir s
 }
 
 func DownloadIContent(content types.IContent, w http.ResponseWriter, req *http.Request, forceProxy bool) error {
+	if req.Header.Get("Range") != "" {
+		if _, ok := content.(types.IRangeContent); ok {
+			// content can fetch an arbitrary byte range from the backend
+			// itself; ask it directly for just the range instead of
+			// reverse-proxying the whole GetURL response (which would also
+			// work, since proxying forwards Range upstream unmodified, but
+			// gives us no control over 416s/multi-range and an extra hop).
+			return ServeRangeContent(content, w, req)
+		}
+	}
+
 	u, e := content.GetURL()
 	if e == nil {
 		if u.Proxy || forceProxy || u.Header != nil {
@@ -142,6 +165,15 @@ func DownloadIContent(content types.IContent, w http.ResponseWriter, req *http.R
 		return nil
 	}
 
+	if req.Header.Get("Range") != "" {
+		// content has already proven above it isn't an IRangeContent, so
+		// there's no server-side range fetch to prefer here; serve the
+		// range off the reader already opened above instead of discarding
+		// it and having ServeRangeContent open a second one.
+		return ServeRangeContentFromReader(content, reader, w, req)
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
 	w.Header().Set("Content-Length", strconv.FormatInt(content.Size(), 10))
 	if req.Method != http.MethodHead {
 		_, e = io.Copy(w, reader)
@@ -159,10 +191,60 @@ type EntryNode struct {
 type DoCopy = func(from types.IEntry, driveTo types.IDrive, to string, ctx types.TaskCtx) error
 type CopyCallback = func(entry types.IEntry, allProcessed bool, ctx types.TaskCtx) error
 
-func buildEntriesTree(entry types.IEntry, ctx types.TaskCtx, bytesProgress bool) (EntryNode, error) {
+// CopyOptions tunes the concurrency and fault tolerance of CopyAll: how
+// many file copies run at once, how failures are retried, and whether one
+// file's failure should abort the rest of the operation.
+type CopyOptions struct {
+	// Workers bounds how many file copies, and List calls while building
+	// the entries tree, run concurrently. <= 0 falls back to the default.
+	Workers int
+	// RetryCount is how many extra attempts a failed file copy gets
+	// before it's reported as failed.
+	RetryCount int
+	// RetryBackoff is the base delay between retries; it doubles after
+	// each failed attempt. <= 0 falls back to the default.
+	RetryBackoff time.Duration
+	// SkipOnError, when true, keeps a failed entry's error from reaching
+	// its parent directory, which instead sees allProcessed == false in its
+	// CopyCallback. When false, the error is passed up and CopyAll returns
+	// it. In both modes the other files of the tree are still copied: a
+	// failure does not stop copies that are running or not yet started.
+	SkipOnError bool
+	// PerFileTimeout bounds a single copy attempt (excluding retries).
+	// Zero means no limit.
+	PerFileTimeout time.Duration
+}
+
+const defaultCopyWorkers = 4
+const defaultCopyRetryBackoff = 500 * time.Millisecond
+
+// DefaultCopyOptions returns the concurrency/retry settings CopyAll uses
+// when no CopyOptions is given: a small worker pool and a couple of
+// retries, with SkipOnError off, so a file that still fails after its
+// retries makes CopyAll return an error.
+func DefaultCopyOptions() CopyOptions {
+	return CopyOptions{Workers: defaultCopyWorkers, RetryCount: 2, RetryBackoff: defaultCopyRetryBackoff}
+}
+
+func (o CopyOptions) workers() int {
+	if o.Workers <= 0 {
+		return defaultCopyWorkers
+	}
+	return o.Workers
+}
+
+func (o CopyOptions) retryBackoff() time.Duration {
+	if o.RetryBackoff <= 0 {
+		return defaultCopyRetryBackoff
+	}
+	return o.RetryBackoff
+}
+
+func buildEntriesTree(entry types.IEntry, ctx types.TaskCtx, bytesProgress bool, pool chan struct{}) (EntryNode, error) {
 	if ctx.Canceled() {
 		return EntryNode{}, task.ErrorCanceled
 	}
+	ctxProgressMu.Lock()
 	if bytesProgress {
 		if entry.Type().IsFile() {
 			ctx.Total(entry.Size(), false)
@@ -170,31 +252,58 @@ func buildEntriesTree(entry types.IEntry, ctx types.TaskCtx, bytesProgress bool)
 	} else {
 		ctx.Total(1, false)
 	}
+	ctxProgressMu.Unlock()
+
 	r := EntryNode{entry, nil}
 	if entry.Type().IsFile() {
 		return r, nil
 	}
+
+	pool <- struct{}{}
 	entries, e := entry.Drive().List(entry.Path())
+	<-pool
 	if e != nil {
 		return r, e
 	}
+
 	children := make([]EntryNode, len(entries))
-	for i, e := range entries {
-		node, ee := buildEntriesTree(e, ctx, bytesProgress)
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	for i, child := range entries {
+		wg.Add(1)
+		go func(i int, child types.IEntry) {
+			defer wg.Done()
+			node, ee := buildEntriesTree(child, ctx, bytesProgress, pool)
+			children[i], errs[i] = node, ee
+		}(i, child)
+	}
+	wg.Wait()
+
+	for _, ee := range errs {
 		if ee != nil {
 			return r, ee
 		}
-		children[i] = node
 	}
 	r.children = children
 	return r, nil
 }
 
 func BuildEntriesTree(root types.IEntry, ctx types.TaskCtx, bytesProgress bool) (EntryNode, error) {
+	return buildEntriesTreeWithWorkers(root, ctx, bytesProgress, defaultCopyWorkers)
+}
+
+// buildEntriesTreeWithWorkers is BuildEntriesTree with an explicit List()
+// concurrency, so CopyAllWithOptions can drive the tree walk at the same
+// pool size as CopyOptions.Workers controls for the file copies themselves.
+func buildEntriesTreeWithWorkers(root types.IEntry, ctx types.TaskCtx, bytesProgress bool, workers int) (EntryNode, error) {
 	if ctx == nil {
 		ctx = task.DummyContext()
 	}
-	return buildEntriesTree(root, ctx, bytesProgress)
+	if workers <= 0 {
+		workers = defaultCopyWorkers
+	}
+	pool := make(chan struct{}, workers)
+	return buildEntriesTree(root, ctx, bytesProgress, pool)
 }
 
 func flattenEntriesTree(root EntryNode, result []EntryNode) []EntryNode {
@@ -212,19 +321,57 @@ func FlattenEntriesTree(root EntryNode) []EntryNode {
 	return flattenEntriesTree(root, result)
 }
 
-func copyAll(entry EntryNode, driveTo types.IDrive, to string, override bool,
-	ctx types.TaskCtx, newParent bool, doCopy DoCopy, after CopyCallback) (bool, error) {
+// copyResult is a handle to the eventual outcome of copying one EntryNode
+// (and, for directories, its whole subtree). copyAllNode returns one once
+// the copy has been started, without waiting for it to finish (for a file
+// it first waits for a free pool slot); wait() blocks until that node (and
+// everything under it) has actually finished.
+type copyResult struct {
+	wg  sync.WaitGroup
+	ok  bool
+	err error
+}
+
+func newCopyResult() *copyResult {
+	r := &copyResult{}
+	r.wg.Add(1)
+	return r
+}
+
+func (r *copyResult) done(ok bool, err error) {
+	r.ok, r.err = ok, err
+	r.wg.Done()
+}
+
+func (r *copyResult) wait() (bool, error) {
+	r.wg.Wait()
+	return r.ok, r.err
+}
+
+// copyAllNode mirrors the old, strictly-serial copyAll, except that each
+// file's doCopy runs on its own goroutine, bounded by pool, so that
+// siblings and cousins anywhere in the tree copy concurrently. Parent
+// directories are still created before their children are visited, so
+// directory structure is always created top-down and in order; only the
+// (independent) file bodies are parallelized.
+func copyAllNode(entry EntryNode, driveTo types.IDrive, to string, override bool,
+	ctx types.TaskCtx, newParent bool, pool chan struct{}, opts CopyOptions,
+	doCopy DoCopy, after CopyCallback) *copyResult {
+
+	result := newCopyResult()
+
 	if ctx.Canceled() {
-		return false, task.ErrorCanceled
+		result.done(false, task.ErrorCanceled)
+		return result
 	}
+
 	var dstType types.EntryType
 	dstExists := false
-	if newParent {
-		dstExists = false
-	} else {
+	if !newParent {
 		dst, e := driveTo.Get(to)
 		if e != nil && !err.IsNotFoundError(e) {
-			return false, e
+			result.done(false, e)
+			return result
 		}
 		dstExists = e == nil
 		if dstExists {
@@ -232,67 +379,193 @@ func copyAll(entry EntryNode, driveTo types.IDrive, to string, override bool,
 		}
 	}
 
-	allProcessed := true
 	if entry.Type().IsDir() {
 		dirCreate := false
 		if dstExists {
 			if dstType.IsFile() {
-				return false, err.NewNotAllowedMessageError(i18n.T("drive.copy_type_mismatch1", entry.Path(), to))
+				result.done(false, err.NewNotAllowedMessageError(i18n.T("drive.copy_type_mismatch1", entry.Path(), to)))
+				return result
 			}
 		} else {
-			_, e := driveTo.MakeDir(to)
-			if e != nil {
-				return false, e
+			if _, e := driveTo.MakeDir(to); e != nil {
+				result.done(false, e)
+				return result
 			}
 			dirCreate = true
 		}
-		if entry.children != nil {
-			for _, e := range entry.children {
-				r, ee := copyAll(e, driveTo, utils.CleanPath(path.Join(to, utils.PathBase(e.Path()))), override, ctx, dirCreate, doCopy, after)
-				if ee != nil {
-					return false, ee
-				}
-				if !r {
+
+		children := make([]*copyResult, 0, len(entry.children))
+		for _, child := range entry.children {
+			childTo := utils.CleanPath(path.Join(to, utils.PathBase(child.Path())))
+			children = append(children, copyAllNode(child, driveTo, childTo, override, ctx, dirCreate, pool, opts, doCopy, after))
+		}
+
+		go func() {
+			allProcessed := true
+			var firstErr error
+			for _, c := range children {
+				ok, e := c.wait()
+				if !ok {
 					allProcessed = false
 				}
+				if e != nil && firstErr == nil {
+					firstErr = e
+				}
+			}
+			if firstErr != nil && !opts.SkipOnError {
+				result.done(false, firstErr)
+				return
+			}
+			if e := after(entry, allProcessed, ctx); e != nil {
+				result.done(false, e)
+				return
 			}
+			result.done(allProcessed, nil)
+		}()
+		return result
+	}
+
+	// file
+	if dstExists {
+		if dstType.IsDir() {
+			result.done(false, err.NewNotAllowedMessageError(i18n.T("drive.copy_type_mismatch2", entry.Path(), to)))
+			return result
+		}
+		if !override {
+			result.done(false, nil) // skip, matching the original strictly-serial behavior
+			return result
 		}
 	}
 
-	if entry.Type().IsFile() {
-		if dstExists {
-			if dstType.IsDir() {
-				return false, err.NewNotAllowedMessageError(i18n.T("drive.copy_type_mismatch2", entry.Path(), to))
-			}
-			if !override {
-				// skip
-				return false, nil
-			}
+	pool <- struct{}{}
+	go func() {
+		defer func() { <-pool }()
+		e := copyFileWithRetry(entry.IEntry, driveTo, to, ctx, opts, doCopy)
+		if e != nil {
+			result.done(false, e)
+			return
+		}
+		if e := after(entry, true, ctx); e != nil {
+			result.done(false, e)
+			return
 		}
+		result.done(true, nil)
+	}()
+	return result
+}
 
-		if e := doCopy(entry.IEntry, driveTo, to, ctx); e != nil {
-			return false, e
+// copyFileWithRetry runs doCopy, retrying with exponential backoff up to
+// opts.RetryCount extra times, and bounding each attempt by
+// opts.PerFileTimeout when set.
+func copyFileWithRetry(from types.IEntry, driveTo types.IDrive, to string, ctx types.TaskCtx, opts CopyOptions, doCopy DoCopy) error {
+	backoff := opts.retryBackoff()
+	var lastErr error
+	for attempt := 0; attempt <= opts.RetryCount; attempt++ {
+		if ctx.Canceled() {
+			return task.ErrorCanceled
+		}
+
+		e := runCopyAttempt(from, driveTo, to, ctx, opts.PerFileTimeout, doCopy)
+		if e == nil {
+			return nil
+		}
+		lastErr = e
+
+		if attempt < opts.RetryCount {
+			time.Sleep(backoff)
+			backoff *= 2
 		}
 	}
-	if e := after(entry, allProcessed, ctx); e != nil {
-		return false, e
+	return lastErr
+}
+
+// errCopyTimeout is returned when a single copy attempt exceeds
+// CopyOptions.PerFileTimeout.
+var errCopyTimeout = errors.New("drive_util: copy attempt timed out")
+
+// timeoutCtx wraps a types.TaskCtx, embedding it so every method other than
+// Canceled is promoted unchanged, and overriding Canceled to additionally
+// report true once cancel is called. It lets runCopyAttempt tell an
+// abandoned, still-running doCopy to stop, relying on the same
+// ctx.Canceled() checks doCopy implementations already make for ordinary
+// cancellation (see Copy, WebDAVDrive.saveChunked).
+type timeoutCtx struct {
+	types.TaskCtx
+	canceled int32
+}
+
+func (c *timeoutCtx) Canceled() bool {
+	return atomic.LoadInt32(&c.canceled) != 0 || c.TaskCtx.Canceled()
+}
+
+func (c *timeoutCtx) cancel() {
+	atomic.StoreInt32(&c.canceled, 1)
+}
+
+func runCopyAttempt(from types.IEntry, driveTo types.IDrive, to string, ctx types.TaskCtx, timeout time.Duration, doCopy DoCopy) error {
+	if timeout <= 0 {
+		return doCopy(from, driveTo, to, ctx)
+	}
+	attemptCtx := &timeoutCtx{TaskCtx: ctx}
+	done := make(chan error, 1)
+	go func() { done <- doCopy(from, driveTo, to, attemptCtx) }()
+	select {
+	case e := <-done:
+		return e
+	case <-time.After(timeout):
+		// Tell the abandoned attempt to stop instead of letting it run
+		// indefinitely alongside the retry we're about to issue.
+		attemptCtx.cancel()
+		return errCopyTimeout
 	}
-	return allProcessed, nil
 }
 
 func CopyAll(entry types.IEntry, driveTo types.IDrive, to string, override bool,
 	ctx types.TaskCtx, doCopy DoCopy, after CopyCallback) error {
-	tree, e := BuildEntriesTree(entry, ctx, true)
-	if e != nil {
-		return e
-	}
+	return CopyAllWithOptions(entry, driveTo, to, override, ctx, doCopy, after, DefaultCopyOptions())
+}
+
+// CopyAllWithOptions is CopyAll with explicit control over worker pool size,
+// retries, and failure handling; see CopyOptions.
+func CopyAllWithOptions(entry types.IEntry, driveTo types.IDrive, to string, override bool,
+	ctx types.TaskCtx, doCopy DoCopy, after CopyCallback, opts CopyOptions) error {
 	if after == nil {
 		after = func(entry types.IEntry, fullProcessed bool, ctx types.TaskCtx) error { return nil }
 	}
-	_, e = copyAll(tree, driveTo, to, override, ctx, false, doCopy, after)
-	return e
+
+	if copier, ok := driveTo.(types.IServerSideCopier); ok && GetIEntry(entry, nil).Drive() == driveTo {
+		// The source and destination live on the same drive, and that drive
+		// can copy/move a whole subtree itself (e.g. WebDAV COPY/MOVE with
+		// Depth: infinity): skip walking and re-uploading every descendant
+		// and let it do the work in one round trip.
+		if _, e := copier.ServerSideCopy(entry, to, override, ctx); e != nil {
+			return e
+		}
+		return after(entry, true, ctx)
+	}
+
+	tree, e := buildEntriesTreeWithWorkers(entry, ctx, true, opts.workers())
+	if e != nil {
+		return e
+	}
+
+	// Lock the destination collection once for the whole subtree, rather
+	// than once per file: the worker pool below copies many sibling files
+	// concurrently, and re-locking the same destination path per file would
+	// serialize them right back, one LOCK/UNLOCK round trip at a time.
+	return withDestinationLock(driveTo, to, func() error {
+		pool := make(chan struct{}, opts.workers())
+		result := copyAllNode(tree, driveTo, to, override, ctx, false, pool, opts, doCopy, after)
+		_, e := result.wait()
+		return e
+	})
 }
 
+// CopyEntry copies a single file's content through a local temp file. It is
+// normally used as CopyAll/CopyAllWithOptions's doCopy callback, which
+// already holds the destination lock for the whole subtree being copied
+// (see withDestinationLock in CopyAllWithOptions), so this does not lock to
+// itself.
 func CopyEntry(from types.IEntry, driveTo types.IDrive, to string, override bool, ctx types.TaskCtx, tempDir string) error {
 	content, ok := from.(types.IContent)
 	if !ok {
@@ -310,6 +583,45 @@ func CopyEntry(from types.IEntry, driveTo types.IDrive, to string, override bool
 	return e
 }
 
+// destinationLockTimeout is the WebDAV lock duration requested around a
+// CopyAll's whole destination subtree; it is refreshed every half of this
+// duration in case the copy (e.g. a large upload) takes longer than that.
+const destinationLockTimeout = 4 * time.Minute
+const destinationLockOwner = "go-drive"
+
+// withDestinationLock acquires a lock on to from driveTo when it implements
+// types.ILockable, runs do, and releases the lock afterwards. Drives that
+// don't require locking (the common case) run do unchanged.
+func withDestinationLock(driveTo types.IDrive, to string, do func() error) error {
+	lockable, ok := driveTo.(types.ILockable)
+	if !ok {
+		return do()
+	}
+
+	token, e := lockable.Lock(to, destinationLockTimeout, destinationLockOwner)
+	if e != nil {
+		return e
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(destinationLockTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				_ = lockable.Refresh(to, token, destinationLockTimeout)
+			}
+		}
+	}()
+	defer func() { _ = lockable.Unlock(to, token) }()
+
+	return do()
+}
+
 // endregion
 
 type progressReader struct {
@@ -320,7 +632,9 @@ type progressReader struct {
 func (p *progressReader) Read(b []byte) (n int, err error) {
 	read, e := p.r.Read(b)
 	if e == nil || e == io.EOF {
+		ctxProgressMu.Lock()
 		p.ctx.Progress(int64(read), false)
+		ctxProgressMu.Unlock()
 	}
 	return read, e
 }
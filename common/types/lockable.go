@@ -0,0 +1,19 @@
+package types
+
+import "time"
+
+// ILockable is implemented by drives whose backend requires an explicit
+// lock to be held before a path can be written (e.g. SabreDAV, Nextcloud,
+// IIS WebDAV). drive_util.CopyAll acquires a lock on the destination path
+// around the whole copy when the destination drive implements this
+// interface; drive_util.CopyEntry on its own does not lock.
+type ILockable interface {
+	// Lock acquires an exclusive write lock on path, valid for timeout,
+	// identified as belonging to owner. It returns an opaque lock token
+	// that must be passed to Unlock/Refresh.
+	Lock(path string, timeout time.Duration, owner string) (token string, err error)
+	// Unlock releases a lock previously obtained with Lock.
+	Unlock(path string, token string) error
+	// Refresh extends a previously obtained lock for another timeout.
+	Refresh(path string, token string, timeout time.Duration) error
+}
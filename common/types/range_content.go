@@ -0,0 +1,13 @@
+package types
+
+import "io"
+
+// IRangeContent is implemented by an IContent whose backend can serve an
+// arbitrary byte range directly (e.g. an HTTP GET with a Range header),
+// letting drive_util.ServeRangeContent satisfy Range requests without
+// downloading the whole file first.
+type IRangeContent interface {
+	// GetRangeReader returns a reader for length bytes starting at offset.
+	// length < 0 means "read to the end of the content".
+	GetRangeReader(offset, length int64) (io.ReadCloser, error)
+}
@@ -0,0 +1,11 @@
+package types
+
+// IServerSideCopier is implemented by drives that can copy (or move) an
+// entry to another path on the same drive entirely on the backend, without
+// the caller reading and re-writing any bytes (e.g. a WebDAV COPY/MOVE
+// request with Depth: infinity). drive_util.CopyAll uses it to skip
+// BuildEntriesTree/CopyEntry entirely when the source and destination are
+// the same drive.
+type IServerSideCopier interface {
+	ServerSideCopy(from IEntry, to string, override bool, ctx TaskCtx) (IEntry, error)
+}
@@ -1,19 +1,24 @@
 package drive
 
 import (
-	"encoding/base64"
+	"bytes"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"go-drive/common/drive_util"
 	"go-drive/common/errors"
 	"go-drive/common/i18n"
 	"go-drive/common/req"
+	"go-drive/common/task"
 	"go-drive/common/types"
 	"go-drive/common/utils"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -22,11 +27,19 @@ import (
 //   - url: root url
 //   - username: if omitted, no authorization is required
 //   - password:
+//   - auth_type: auto(default)|basic|digest|bearer
+//   - token: bearer token, required when auth_type is bearer
 //   - cache_ttl:
+//   - resumable_upload: true|false(default). When true, uploads larger than
+//     one chunk are sent as a series of `Content-Range` PUTs instead of a
+//     single request, so a retried Save resumes from the last acknowledged
+//     offset. Only enable this against servers that support Content-Range
+//     on PUT (e.g. SabreDAV); plain webdav servers reject it.
 func NewWebDAVDrive(config drive_util.DriveConfig, utils drive_util.DriveUtils) (types.IDrive, error) {
 	u := config["url"]
 	username := config["username"]
 	password := config["password"]
+	resumable := config["resumable_upload"] == "true"
 
 	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
 	if e != nil {
@@ -39,7 +52,12 @@ func NewWebDAVDrive(config drive_util.DriveConfig, utils drive_util.DriveUtils)
 	}
 	pathPrefix := uu.Path
 
-	w := &WebDAVDrive{url: u, username: username, password: password, cacheTTL: cacheTtl, pathPrefix: pathPrefix}
+	auth, e := newAuthenticator(config, username, password)
+	if e != nil {
+		return nil, e
+	}
+
+	w := &WebDAVDrive{url: u, auth: auth, cacheTTL: cacheTtl, pathPrefix: pathPrefix, resumable: resumable}
 
 	if cacheTtl <= 0 {
 		w.cache = drive_util.DummyCache()
@@ -61,11 +79,34 @@ func NewWebDAVDrive(config drive_util.DriveConfig, utils drive_util.DriveUtils)
 	return w, nil
 }
 
+func newAuthenticator(config drive_util.DriveConfig, username, password string) (Authenticator, error) {
+	switch config["auth_type"] {
+	case "digest":
+		return &DigestAuth{Username: username, Password: password}, nil
+	case "bearer":
+		return BearerAuth{Token: config["token"]}, nil
+	case "basic":
+		return BasicAuth{Username: username, Password: password}, nil
+	case "", "auto":
+		if username == "" {
+			return noAuth{}, nil
+		}
+		return autoAuth{username: username, password: password}, nil
+	default:
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.webdav.unknown_auth_type", config["auth_type"]))
+	}
+}
+
 type WebDAVDrive struct {
 	url        string
 	pathPrefix string
-	username   string
-	password   string
+	resumable  bool
+
+	// authMu guards auth: beforeRequest reads it and afterRequest replaces
+	// it (on a 401 challenge) from different goroutines once CopyAll's
+	// worker pool has several requests against this drive in flight at once.
+	authMu sync.Mutex
+	auth   Authenticator
 
 	cacheTTL time.Duration
 	cache    drive_util.DriveCache
@@ -73,6 +114,20 @@ type WebDAVDrive struct {
 	c *req.Client
 }
 
+func (w *WebDAVDrive) getAuth() Authenticator {
+	w.authMu.Lock()
+	defer w.authMu.Unlock()
+	return w.auth
+}
+
+func (w *WebDAVDrive) setAuth(a Authenticator) {
+	w.authMu.Lock()
+	w.auth = a
+	w.authMu.Unlock()
+}
+
+var _ types.ILockable = (*WebDAVDrive)(nil)
+
 func (w *WebDAVDrive) Meta() types.DriveMeta {
 	return types.DriveMeta{CanWrite: true}
 }
@@ -81,7 +136,7 @@ func (w *WebDAVDrive) Get(path string) (types.IEntry, error) {
 	if cached, _ := w.cache.GetEntry(path); cached != nil {
 		return cached, nil
 	}
-	resp, e := w.c.Request("PROPFIND", utils.BuildURL(path), types.SM{"Depth": "0"}, nil)
+	resp, e := w.doRequest("PROPFIND", utils.BuildURL(path), types.SM{"Depth": "0"}, nil)
 	if e != nil {
 		return nil, e
 	}
@@ -104,19 +159,100 @@ func (w *WebDAVDrive) Save(path string, size int64, override bool, reader io.Rea
 			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
 		}
 	}
-	resp, e := w.c.RequestWithContext("PUT", path, nil,
-		req.NewReaderBody(drive_util.ProgressReader(reader, ctx), size), ctx)
+
+	var e error
+	if w.resumable && size > resumableChunkSize {
+		e = w.saveChunked(path, size, reader, ctx)
+	} else {
+		var resp req.Response
+		resp, e = w.doRequestCtx("PUT", path, nil,
+			req.NewReaderBody(drive_util.ProgressReader(reader, ctx), size), ctx)
+		if e == nil {
+			_ = resp.Dispose()
+		}
+	}
 	if e != nil {
 		return nil, e
 	}
-	_ = resp.Dispose()
 	_ = w.cache.Evict(utils.PathParent(path), false)
 	_ = w.cache.Evict(path, false)
 	return w.Get(path)
 }
 
+// resumableChunkSize is the size of each Content-Range PUT issued by
+// saveChunked.
+const resumableChunkSize = 8 * 1024 * 1024
+
+// resumeOffsets tracks, per destination path, how many bytes of a chunked
+// upload the server has already acknowledged, so a Save retried against the
+// same path (e.g. drive_util's per-file copy retry) resumes instead of
+// re-uploading from the start. Keying on path rather than ctx matters
+// because a timed-out retry (drive_util's runCopyAttempt) runs under a
+// fresh wrapper ctx on every attempt, so a ctx-keyed map would never see
+// the same key twice; the destination lock (withDestinationLock) is what
+// keeps two unrelated uploads from colliding on the same path.
+var resumeOffsets = struct {
+	mu      sync.Mutex
+	offsets map[string]int64
+}{offsets: make(map[string]int64)}
+
+func resumeKey(path string) string {
+	return path
+}
+
+// saveChunked uploads reader in resumableChunkSize pieces using
+// `Content-Range: bytes start-end/size` PUTs. If a previous attempt against
+// the same path got partway through, it skips the bytes already
+// acknowledged instead of restarting the whole upload.
+func (w *WebDAVDrive) saveChunked(path string, size int64, reader io.Reader, ctx types.TaskCtx) error {
+	key := resumeKey(path)
+
+	resumeOffsets.mu.Lock()
+	offset := resumeOffsets.offsets[key]
+	resumeOffsets.mu.Unlock()
+
+	if offset > 0 {
+		if _, e := io.CopyN(ioutil.Discard, reader, offset); e != nil {
+			return e
+		}
+	}
+
+	buf := make([]byte, resumableChunkSize)
+	for offset < size {
+		if ctx.Canceled() {
+			return task.ErrorCanceled
+		}
+
+		n, e := io.ReadFull(reader, buf)
+		if n == 0 && (e == io.EOF || e == io.ErrUnexpectedEOF) {
+			return err.NewNotAllowedMessageError(i18n.T("drive.webdav.upload_truncated", path))
+		}
+		if e != nil && e != io.ErrUnexpectedEOF && e != io.EOF {
+			return e
+		}
+		chunk := drive_util.ProgressReader(bytes.NewReader(buf[:n]), ctx)
+
+		header := types.SM{"Content-Range": fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(n)-1, size)}
+		resp, e := w.doRequestCtx("PUT", path, header, req.NewReaderBody(chunk, int64(n)), ctx)
+		if e != nil {
+			return e
+		}
+		_ = resp.Dispose()
+
+		offset += int64(n)
+		resumeOffsets.mu.Lock()
+		resumeOffsets.offsets[key] = offset
+		resumeOffsets.mu.Unlock()
+	}
+
+	resumeOffsets.mu.Lock()
+	delete(resumeOffsets.offsets, key)
+	resumeOffsets.mu.Unlock()
+	return nil
+}
+
 func (w *WebDAVDrive) MakeDir(path string) (types.IEntry, error) {
-	resp, e := w.c.Request("MKCOL", path, nil, nil)
+	resp, e := w.doRequest("MKCOL", path, nil, nil)
 	if e != nil {
 		return nil, e
 	}
@@ -134,7 +270,7 @@ func (w *WebDAVDrive) isSelf(e types.IEntry) bool {
 
 func (w *WebDAVDrive) copyOrMove(method string, from types.IEntry, to string, override bool, ctx types.TaskCtx) (types.IEntry, error) {
 	from = drive_util.GetIEntry(from, w.isSelf)
-	if from == nil || from.Type().IsDir() {
+	if from == nil {
 		return nil, err.NewUnsupportedError()
 	}
 	wEntry := from.(*webDavEntry)
@@ -146,12 +282,24 @@ func (w *WebDAVDrive) copyOrMove(method string, from types.IEntry, to string, ov
 	if !override {
 		header["Overwrite"] = "F"
 	}
-	resp, e := w.c.RequestWithContext(method, wEntry.path, header, nil, ctx)
+	if wEntry.Type().IsDir() {
+		// MOVE of a collection is always recursive (RFC 4918 section 9.9); COPY
+		// needs an explicit Depth: infinity to copy the collection's
+		// members too (section 9.8.3), otherwise only the empty directory is made.
+		header["Depth"] = "infinity"
+	}
+	resp, e := w.doRequestCtx(method, wEntry.path, header, nil, ctx)
 	if e != nil && !(!override && e == errorPreconditionFailed) {
 		return nil, e
 	}
 	if e == nil {
+		if resp.Status() == http.StatusMultiStatus {
+			e = firstMultiStatusError(resp)
+		}
 		_ = resp.Dispose()
+		if e != nil {
+			return nil, e
+		}
 	}
 	_ = w.cache.Evict(to, true)
 	_ = w.cache.Evict(utils.PathParent(to), false)
@@ -162,6 +310,44 @@ func (w *WebDAVDrive) copyOrMove(method string, from types.IEntry, to string, ov
 	return w.Get(to)
 }
 
+// copyMultiStatus is the subset of a COPY/MOVE 207 Multi-Status response
+// (RFC 4918 section 9.8.6/section 9.9.4) this drive cares about: when a recursive
+// copy/move partially fails, the overall request still returns 207 and the
+// individual failures are only reported per-resource here.
+type copyMultiStatus struct {
+	Response []copyStatusResponse `xml:"response"`
+}
+
+type copyStatusResponse struct {
+	Href   string `xml:"href"`
+	Status string `xml:"status"`
+}
+
+// firstMultiStatusError returns an error describing the first non-2xx
+// per-resource status in a COPY/MOVE 207 response, or nil if every member
+// of the collection was copied/moved successfully.
+func firstMultiStatusError(resp req.Response) error {
+	res := copyMultiStatus{}
+	if e := resp.XML(&res); e != nil {
+		return e
+	}
+	for _, r := range res.Response {
+		if code := statusCodeOf(r.Status); code < 200 || code >= 300 {
+			return err.NewRemoteApiError(code, i18n.T("drive.webdav.remote_error", r.Status))
+		}
+	}
+	return nil
+}
+
+func statusCodeOf(status string) int {
+	parts := strings.SplitN(status, " ", 3)
+	if len(parts) < 2 {
+		return 0
+	}
+	code, _ := strconv.Atoi(parts[1])
+	return code
+}
+
 func (w *WebDAVDrive) Copy(from types.IEntry, to string, override bool, ctx types.TaskCtx) (types.IEntry, error) {
 	return w.copyOrMove("COPY", from, to, override, ctx)
 }
@@ -170,11 +356,21 @@ func (w *WebDAVDrive) Move(from types.IEntry, to string, override bool, ctx type
 	return w.copyOrMove("MOVE", from, to, override, ctx)
 }
 
+var _ types.IServerSideCopier = (*WebDAVDrive)(nil)
+
+// ServerSideCopy lets drive_util.CopyAll skip walking a directory tree and
+// re-uploading every file when both ends of the copy are this same WebDAV
+// drive: copyOrMove already asks the server itself to do a recursive
+// COPY/MOVE via Depth: infinity.
+func (w *WebDAVDrive) ServerSideCopy(from types.IEntry, to string, override bool, ctx types.TaskCtx) (types.IEntry, error) {
+	return w.Copy(from, to, override, ctx)
+}
+
 func (w *WebDAVDrive) List(path string) ([]types.IEntry, error) {
 	if cached, _ := w.cache.GetChildren(path); cached != nil {
 		return cached, nil
 	}
-	resp, e := w.c.Request("PROPFIND", utils.BuildURL(path), types.SM{"Depth": "1"}, nil)
+	resp, e := w.doRequest("PROPFIND", utils.BuildURL(path), types.SM{"Depth": "1"}, nil)
 	if e != nil {
 		return nil, e
 	}
@@ -195,7 +391,7 @@ func (w *WebDAVDrive) List(path string) ([]types.IEntry, error) {
 }
 
 func (w *WebDAVDrive) Delete(path string, _ types.TaskCtx) error {
-	resp, e := w.c.Request("DELETE", path, nil, nil)
+	resp, e := w.doRequest("DELETE", path, nil, nil)
 	if e != nil {
 		return e
 	}
@@ -210,14 +406,192 @@ func (w *WebDAVDrive) Upload(_ string, size int64, _ bool, _ types.SM) (*types.D
 }
 
 func (w *WebDAVDrive) beforeRequest(req *http.Request) error {
-	if w.username != "" {
-		req.SetBasicAuth(w.username, w.password)
+	if e := w.getAuth().Authorize(req, req.Method, req.URL.Path); e != nil {
+		return e
+	}
+	switch req.Method {
+	case http.MethodPut, "MOVE", http.MethodDelete:
+		if token := w.heldLockToken(req.URL.Path); token != "" {
+			req.Header.Set("If", "(<"+token+">)")
+		}
 	}
 	return nil
 }
 
 var errorPreconditionFailed = errors.New("precondition failed")
 
+// errorAuthChallenge is returned by afterRequest when a 401 response swaps
+// w.auth for an Authenticator derived from the challenge; doRequest and
+// doRequestCtx retry the request exactly once when they see it.
+var errorAuthChallenge = errors.New("auth challenge")
+
+// doRequest wraps req.Client.Request with a single automatic retry when the
+// first attempt is met with a 401 whose challenge the current Authenticator's
+// OnChallenge accepts (see Authenticator.OnChallenge). The retry uses the
+// Authenticator OnChallenge returned, which for Basic and Bearer is the same
+// one as before.
+func (w *WebDAVDrive) doRequest(method, path string, header types.SM, body req.Body) (req.Response, error) {
+	resp, e := w.c.Request(method, path, header, body)
+	if e == errorAuthChallenge {
+		resp, e = w.c.Request(method, path, header, body)
+		if e == errorAuthChallenge {
+			e = err.NewUnauthorizedError(i18n.T("drive.webdav.wrong_user_or_password"))
+		}
+	}
+	return resp, e
+}
+
+// doRequestCtx is the types.TaskCtx-aware equivalent of doRequest. Requests
+// carrying a body are not retried, since the body reader may already have
+// been partially consumed by the first attempt.
+func (w *WebDAVDrive) doRequestCtx(method, path string, header types.SM, body req.Body, ctx types.TaskCtx) (req.Response, error) {
+	resp, e := w.c.RequestWithContext(method, path, header, body, ctx)
+	if e == errorAuthChallenge {
+		if body == nil {
+			resp, e = w.c.RequestWithContext(method, path, header, body, ctx)
+		}
+		if e == errorAuthChallenge {
+			e = err.NewUnauthorizedError(i18n.T("drive.webdav.wrong_user_or_password"))
+		}
+	}
+	return resp, e
+}
+
+// lockRegistry is a small in-memory store of the lock tokens this process
+// currently holds, keyed by (drive, path), so recursive operations against
+// the same destination reuse a single lock instead of acquiring one per file.
+var lockRegistry = struct {
+	mu     sync.Mutex
+	tokens map[string]string
+}{tokens: make(map[string]string)}
+
+func lockRegistryKey(w *WebDAVDrive, path string) string {
+	return fmt.Sprintf("%p:%s", w, path)
+}
+
+// heldLockToken returns the lock token this drive currently holds for the
+// given request path (the raw, URL-encoded path as seen by http.Request) or
+// for the nearest locked collection above it, or "" if no lock is held.
+func (w *WebDAVDrive) heldLockToken(reqURLPath string) string {
+	p, e := url.PathUnescape(reqURLPath)
+	if e != nil {
+		p = reqURLPath
+	}
+	if len(p) >= len(w.pathPrefix) {
+		p = p[len(w.pathPrefix):]
+	}
+	p = utils.CleanPath(p)
+	lockRegistry.mu.Lock()
+	defer lockRegistry.mu.Unlock()
+	for {
+		if token, ok := lockRegistry.tokens[lockRegistryKey(w, p)]; ok {
+			return token
+		}
+		parent := utils.PathParent(p)
+		if parent == p {
+			return ""
+		}
+		p = parent
+	}
+}
+
+// Lock acquires a class-2 WebDAV exclusive write lock on path, reusing an
+// already-held token for the same (drive, path) if one is registered.
+func (w *WebDAVDrive) Lock(path string, timeout time.Duration, owner string) (string, error) {
+	key := lockRegistryKey(w, path)
+
+	lockRegistry.mu.Lock()
+	if token, ok := lockRegistry.tokens[key]; ok {
+		lockRegistry.mu.Unlock()
+		return token, nil
+	}
+	lockRegistry.mu.Unlock()
+
+	body := davLockInfo{
+		XmlnsD:    "DAV:",
+		LockScope: davLockScope{Exclusive: &struct{}{}},
+		LockType:  davLockType{Write: &struct{}{}},
+		Owner:     davLockOwner{Href: owner},
+	}
+	data, e := xml.Marshal(body)
+	if e != nil {
+		return "", e
+	}
+	header := types.SM{"Timeout": "Second-" + strconv.Itoa(int(timeout.Seconds()))}
+	resp, e := w.doRequest("LOCK", path, header, req.NewReaderBody(bytes.NewReader(data), int64(len(data))))
+	if e != nil {
+		if e == errorPreconditionFailed {
+			return "", err.NewLockedError(i18n.T("drive.webdav.locked", path))
+		}
+		return "", e
+	}
+	defer func() { _ = resp.Dispose() }()
+
+	token := parseLockToken(resp.Response().Header.Get("Lock-Token"))
+	if token == "" {
+		return "", err.NewRemoteApiError(500, i18n.T("drive.webdav.lock_failed"))
+	}
+
+	lockRegistry.mu.Lock()
+	lockRegistry.tokens[key] = token
+	lockRegistry.mu.Unlock()
+	return token, nil
+}
+
+// Unlock releases a lock previously obtained with Lock.
+func (w *WebDAVDrive) Unlock(path string, token string) error {
+	resp, e := w.doRequest("UNLOCK", path, types.SM{"Lock-Token": "<" + token + ">"}, nil)
+	if e != nil {
+		return e
+	}
+	_ = resp.Dispose()
+	lockRegistry.mu.Lock()
+	delete(lockRegistry.tokens, lockRegistryKey(w, path))
+	lockRegistry.mu.Unlock()
+	return nil
+}
+
+// Refresh extends a previously obtained lock for another timeout.
+func (w *WebDAVDrive) Refresh(path string, token string, timeout time.Duration) error {
+	header := types.SM{
+		"If":      "(<" + token + ">)",
+		"Timeout": "Second-" + strconv.Itoa(int(timeout.Seconds())),
+	}
+	resp, e := w.doRequest("LOCK", path, header, nil)
+	if e != nil {
+		return e
+	}
+	_ = resp.Dispose()
+	return nil
+}
+
+func parseLockToken(header string) string {
+	if len(header) < 2 || header[0] != '<' || header[len(header)-1] != '>' {
+		return ""
+	}
+	return header[1 : len(header)-1] // strip surrounding angle brackets
+}
+
+type davLockInfo struct {
+	XMLName   xml.Name     `xml:"D:lockinfo"`
+	XmlnsD    string       `xml:"xmlns:D,attr"`
+	LockScope davLockScope `xml:"D:lockscope"`
+	LockType  davLockType  `xml:"D:locktype"`
+	Owner     davLockOwner `xml:"D:owner"`
+}
+
+type davLockScope struct {
+	Exclusive *struct{} `xml:"D:exclusive"`
+}
+
+type davLockType struct {
+	Write *struct{} `xml:"D:write"`
+}
+
+type davLockOwner struct {
+	Href string `xml:"D:href"`
+}
+
 func (w *WebDAVDrive) afterRequest(resp req.Response) error {
 	if resp.Status() < 200 || resp.Status() >= 300 {
 		if resp.Status() == http.StatusNotFound {
@@ -227,6 +601,10 @@ func (w *WebDAVDrive) afterRequest(resp req.Response) error {
 			return errorPreconditionFailed
 		}
 		if resp.Status() == http.StatusUnauthorized {
+			if newAuth, e := w.getAuth().OnChallenge(resp.Response()); e == nil {
+				w.setAuth(newAuth)
+				return errorAuthChallenge
+			}
 			return err.NewUnauthorizedError(i18n.T("drive.webdav.wrong_user_or_password"))
 		}
 		return err.NewRemoteApiError(500, i18n.T("drive.webdav.remote_error", strconv.Itoa(resp.Status())))
@@ -309,6 +687,25 @@ func (w *webDavEntry) GetReader() (io.ReadCloser, error) {
 	return resp.Response().Body, nil
 }
 
+var _ types.IRangeContent = (*webDavEntry)(nil)
+
+// GetRangeReader issues a ranged GET, letting drive_util.ServeRangeContent
+// satisfy Range requests against this entry without downloading it whole.
+func (w *webDavEntry) GetRangeReader(offset, length int64) (io.ReadCloser, error) {
+	resp, e := w.d.c.Get(w.path, types.SM{"Range": rangeHeaderValue(offset, length)})
+	if e != nil {
+		return nil, e
+	}
+	return resp.Response().Body, nil
+}
+
+func rangeHeaderValue(offset, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
 func (w *webDavEntry) GetURL() (*types.ContentURL, error) {
 	if !w.Type().IsFile() {
 		return nil, err.NewNotAllowedError()
@@ -317,11 +714,12 @@ func (w *webDavEntry) GetURL() (*types.ContentURL, error) {
 	if e != nil {
 		return nil, e
 	}
-	var header types.SM = nil
-	if w.d.username != "" {
-		header = types.SM{
-			"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(w.d.username+":"+w.d.password)),
-		}
+	// Digest credentials are per-request and can't be handed to the browser
+	// as a static header, so only StaticAuthHeader Authenticators (Basic,
+	// Bearer) populate Header; the proxy always carries the request either way.
+	var header types.SM
+	if sa, ok := w.d.getAuth().(StaticAuthHeader); ok {
+		header = sa.Header()
 	}
 	return &types.ContentURL{URL: u, Proxy: true, Header: header}, nil
 }
@@ -0,0 +1,223 @@
+package drive
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Authenticator computes the credentials WebDAVDrive attaches to outgoing
+// requests. Authorize is called for every request; OnChallenge is given
+// the first 401 response so it can resolve itself (or hand back a more
+// specific Authenticator) from the server's WWW-Authenticate header.
+type Authenticator interface {
+	Authorize(req *http.Request, method, path string) error
+	OnChallenge(resp *http.Response) (Authenticator, error)
+}
+
+// StaticAuthHeader is implemented by Authenticators whose credentials are
+// the same on every request (Basic, Bearer), so they can be handed to a
+// browser as a plain header. DigestAuth does not implement it, since each
+// request needs a freshly computed response value.
+type StaticAuthHeader interface {
+	Header() types.SM
+}
+
+// noAuth is used when no credentials were configured.
+type noAuth struct{}
+
+func (noAuth) Authorize(*http.Request, string, string) error     { return nil }
+func (noAuth) OnChallenge(*http.Response) (Authenticator, error) { return noAuth{}, nil }
+
+// BasicAuth implements HTTP Basic authentication (RFC 7617).
+type BasicAuth struct {
+	Username, Password string
+}
+
+func (b BasicAuth) Authorize(req *http.Request, _, _ string) error {
+	req.SetBasicAuth(b.Username, b.Password)
+	return nil
+}
+
+func (b BasicAuth) OnChallenge(*http.Response) (Authenticator, error) { return b, nil }
+
+func (b BasicAuth) Header() types.SM {
+	return types.SM{
+		"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(b.Username+":"+b.Password)),
+	}
+}
+
+// BearerAuth sends a static bearer token, e.g. for OIDC/OAuth2-fronted
+// WebDAV servers.
+type BearerAuth struct {
+	Token string
+}
+
+func (b BearerAuth) Authorize(req *http.Request, _, _ string) error {
+	req.Header.Set("Authorization", "Bearer "+b.Token)
+	return nil
+}
+
+func (b BearerAuth) OnChallenge(*http.Response) (Authenticator, error) { return b, nil }
+
+func (b BearerAuth) Header() types.SM {
+	return types.SM{"Authorization": "Bearer " + b.Token}
+}
+
+// autoAuth starts out sending Basic credentials and, on the first 401,
+// inspects the challenge to decide whether the server actually wants
+// Digest instead.
+type autoAuth struct {
+	username, password string
+}
+
+func (a autoAuth) Authorize(req *http.Request, method, path string) error {
+	return BasicAuth{a.username, a.password}.Authorize(req, method, path)
+}
+
+func (a autoAuth) OnChallenge(resp *http.Response) (Authenticator, error) {
+	if isDigestChallenge(resp.Header.Get("WWW-Authenticate")) {
+		return (&DigestAuth{Username: a.username, Password: a.password}).OnChallenge(resp)
+	}
+	return BasicAuth{a.username, a.password}, nil
+}
+
+func (a autoAuth) Header() types.SM {
+	return BasicAuth{a.username, a.password}.Header()
+}
+
+func isDigestChallenge(challenge string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(challenge)), "digest")
+}
+
+// DigestAuth implements RFC 7616 Digest authentication (MD5 and SHA-256,
+// qop=auth). It carries no usable nonce until OnChallenge parses one out
+// of a 401 response.
+type DigestAuth struct {
+	Username, Password string
+
+	mu        sync.Mutex
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	nc        int
+}
+
+func (d *DigestAuth) Authorize(req *http.Request, method, path string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.nonce == "" {
+		// No challenge seen yet; let the server 401 and call OnChallenge.
+		return nil
+	}
+
+	d.nc++
+	nc := fmt.Sprintf("%08x", d.nc)
+	cnonce := randomHex(8)
+	ha1 := d.hash(d.Username + ":" + d.realm + ":" + d.Password)
+	ha2 := d.hash(method + ":" + path)
+	response := d.hash(strings.Join([]string{ha1, d.nonce, nc, cnonce, d.qop, ha2}, ":"))
+
+	header := fmt.Sprintf(
+		`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s, response="%s", qop=%s, nc=%s, cnonce="%s"`,
+		d.Username, d.realm, d.nonce, path, d.algorithmName(), response, d.qop, nc, cnonce)
+	if d.opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, d.opaque)
+	}
+	req.Header.Set("Authorization", header)
+	return nil
+}
+
+func (d *DigestAuth) OnChallenge(resp *http.Response) (Authenticator, error) {
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if !isDigestChallenge(challenge) {
+		return nil, err.NewUnauthorizedError(i18n.T("drive.webdav.wrong_user_or_password"))
+	}
+	params := parseAuthParams(challenge)
+
+	d.mu.Lock()
+	d.realm = params["realm"]
+	d.nonce = params["nonce"]
+	d.opaque = params["opaque"]
+	d.algorithm = params["algorithm"]
+	d.qop = firstQop(params["qop"])
+	d.nc = 0
+	d.mu.Unlock()
+
+	return d, nil
+}
+
+func (d *DigestAuth) algorithmName() string {
+	if d.algorithm == "" {
+		return "MD5"
+	}
+	return d.algorithm
+}
+
+func (d *DigestAuth) hash(s string) string {
+	if strings.EqualFold(d.algorithm, "SHA-256") {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func firstQop(qop string) string {
+	for _, p := range strings.Split(qop, ",") {
+		if p = strings.TrimSpace(p); p == "auth" {
+			return p
+		}
+	}
+	return "auth"
+}
+
+// parseAuthParams parses the comma-separated, optionally-quoted key=value
+// pairs of a WWW-Authenticate challenge, skipping the leading scheme name.
+func parseAuthParams(challenge string) map[string]string {
+	params := map[string]string{}
+	idx := strings.IndexByte(challenge, ' ')
+	if idx < 0 {
+		return params
+	}
+	// split on commas outside quotes, so qop="auth,auth-int" stays one pair
+	var parts []string
+	inQuotes, start := false, idx+1
+	for i := idx + 1; i < len(challenge); i++ {
+		switch challenge[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				parts = append(parts, challenge[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, challenge[start:])
+	for _, part := range parts {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+	return params
+}
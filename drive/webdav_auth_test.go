@@ -0,0 +1,55 @@
+package drive
+
+import "testing"
+
+func TestIsDigestChallenge(t *testing.T) {
+	cases := map[string]bool{
+		`Digest realm="x", qop="auth", nonce="abc"`: true,
+		`  digest realm="x"`:                        true,
+		`Basic realm="x"`:                           false,
+		``:                                          false,
+	}
+	for challenge, want := range cases {
+		if got := isDigestChallenge(challenge); got != want {
+			t.Errorf("isDigestChallenge(%q) = %v, want %v", challenge, got, want)
+		}
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	challenge := `Digest realm="example.com", qop="auth,auth-int", nonce="abcd1234", opaque="5ccc069c", algorithm=MD5`
+	params := parseAuthParams(challenge)
+
+	want := map[string]string{
+		"realm":     "example.com",
+		"qop":       "auth,auth-int",
+		"nonce":     "abcd1234",
+		"opaque":    "5ccc069c",
+		"algorithm": "MD5",
+	}
+	for k, v := range want {
+		if params[k] != v {
+			t.Errorf("params[%q] = %q, want %q", k, params[k], v)
+		}
+	}
+}
+
+func TestParseAuthParamsNoParams(t *testing.T) {
+	if params := parseAuthParams("Basic"); len(params) != 0 {
+		t.Errorf("expected no params, got %v", params)
+	}
+}
+
+func TestFirstQop(t *testing.T) {
+	cases := map[string]string{
+		"auth":           "auth",
+		"auth-int":       "auth",
+		"auth-int, auth": "auth",
+		"":               "auth",
+	}
+	for qop, want := range cases {
+		if got := firstQop(qop); got != want {
+			t.Errorf("firstQop(%q) = %q, want %q", qop, got, want)
+		}
+	}
+}
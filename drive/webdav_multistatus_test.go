@@ -0,0 +1,49 @@
+package drive
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestCopyMultiStatusUnmarshal(t *testing.T) {
+	body := []byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:multistatus xmlns:D="DAV:">
+  <D:response>
+    <D:href>/a/b.txt</D:href>
+    <D:status>HTTP/1.1 200 OK</D:status>
+  </D:response>
+  <D:response>
+    <D:href>/a/c.txt</D:href>
+    <D:status>HTTP/1.1 423 Locked</D:status>
+  </D:response>
+</D:multistatus>`)
+
+	var res copyMultiStatus
+	if e := xml.Unmarshal(body, &res); e != nil {
+		t.Fatalf("unmarshal error: %v", e)
+	}
+	if len(res.Response) != 2 {
+		t.Fatalf("got %d responses, want 2", len(res.Response))
+	}
+	if res.Response[0].Href != "/a/b.txt" || statusCodeOf(res.Response[0].Status) != 200 {
+		t.Errorf("unexpected first response: %+v", res.Response[0])
+	}
+	if res.Response[1].Href != "/a/c.txt" || statusCodeOf(res.Response[1].Status) != 423 {
+		t.Errorf("unexpected second response: %+v", res.Response[1])
+	}
+}
+
+func TestStatusCodeOf(t *testing.T) {
+	cases := map[string]int{
+		"HTTP/1.1 200 OK":     200,
+		"HTTP/1.1 423 Locked": 423,
+		"HTTP/1.1 404":        404,
+		"":                    0,
+		"garbage":             0,
+	}
+	for status, want := range cases {
+		if got := statusCodeOf(status); got != want {
+			t.Errorf("statusCodeOf(%q) = %d, want %d", status, got, want)
+		}
+	}
+}
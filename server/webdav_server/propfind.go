@@ -0,0 +1,140 @@
+package webdav_server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// davMultiStatus is the response body of a PROPFIND request.
+type davMultiStatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	XmlnsD    string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	PropStat davPropStat `xml:"D:propstat"`
+}
+
+type davPropStat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	DisplayName      string          `xml:"D:displayname"`
+	GetLastModified  string          `xml:"D:getlastmodified,omitempty"`
+	GetContentLength string          `xml:"D:getcontentlength,omitempty"`
+	GetETag          string          `xml:"D:getetag,omitempty"`
+	ResourceType     davResourceType `xml:"D:resourcetype"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+func (s *Server) handlePropfind(w http.ResponseWriter, r *http.Request, reqPath string) error {
+	entry, e := s.drive.Get(reqPath)
+	if e != nil {
+		return e
+	}
+
+	depth := r.Header.Get("Depth")
+	if depth == "" {
+		depth = "infinity"
+	}
+
+	entries := []types.IEntry{entry}
+	if entry.Type().IsDir() && depth != "0" {
+		children, e := s.collectChildren(entry, depth == "infinity")
+		if e != nil {
+			return e
+		}
+		entries = append(entries, children...)
+	}
+
+	ms := davMultiStatus{XmlnsD: "DAV:"}
+	for _, en := range entries {
+		ms.Responses = append(ms.Responses, s.toDavResponse(en, reqPath))
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(207)
+	_, _ = w.Write([]byte(xml.Header))
+	return xml.NewEncoder(w).Encode(ms)
+}
+
+func (s *Server) collectChildren(entry types.IEntry, recursive bool) ([]types.IEntry, error) {
+	children, e := entry.Drive().List(entry.Path())
+	if e != nil {
+		return nil, e
+	}
+	result := make([]types.IEntry, 0, len(children))
+	for _, c := range children {
+		result = append(result, c)
+		if recursive && c.Type().IsDir() {
+			sub, e := s.collectChildren(c, true)
+			if e != nil {
+				return nil, e
+			}
+			result = append(result, sub...)
+		}
+	}
+	return result, nil
+}
+
+func (s *Server) toDavResponse(entry types.IEntry, requestPath string) davResponse {
+	href := entry.Path()
+	if href == requestPath {
+		href = requestPath
+	}
+	href = path.Clean("/" + href)
+	if entry.Type().IsDir() && href != "/" {
+		href = href + "/"
+	}
+
+	prop := davProp{DisplayName: utils.PathBase(entry.Path())}
+	if entry.Type().IsDir() {
+		prop.ResourceType.Collection = &struct{}{}
+	} else {
+		prop.GetContentLength = strconv.FormatInt(entry.Size(), 10)
+	}
+	prop.GetLastModified = utils.Time(entry.ModTime()).UTC().Format(http.TimeFormat)
+	prop.GetETag = entryETag(entry)
+
+	return davResponse{
+		Href: encodeHrefPath(href),
+		PropStat: davPropStat{
+			Prop:   prop,
+			Status: "HTTP/1.1 200 OK",
+		},
+	}
+}
+
+// encodeHrefPath percent-encodes each segment of a clean, slash-separated
+// path so the href is safe for WebDAV clients to echo back verbatim in a
+// follow-up GET/PROPFIND request, even when the entry name has spaces, `#`,
+// `?` or non-ASCII characters. The leading/trailing slashes that mark a
+// collection are preserved since splitting on "/" yields empty segments at
+// those positions, which url.PathEscape leaves as "".
+func encodeHrefPath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+// entryETag synthesizes an entity tag from an entry's modification time and
+// size, since types.IEntry does not otherwise expose one.
+func entryETag(entry types.IEntry) string {
+	return fmt.Sprintf(`"%x-%x"`, entry.ModTime(), entry.Size())
+}
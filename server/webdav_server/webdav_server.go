@@ -0,0 +1,198 @@
+// Package webdav_server exposes a types.IDrive tree as a WebDAV endpoint,
+// so third-party WebDAV clients (Finder, Windows Explorer, gowebdav, rclone,
+// ...) can mount go-drive directly, regardless of which backend drives are
+// actually behind the root.
+package webdav_server
+
+import (
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// Authenticate resolves the requesting user/session from an incoming
+// request, reusing whatever mechanism the rest of the server already uses
+// (cookie session, Basic, ...). It must return an err.NewUnauthorizedError
+// when no valid identity can be established, in which case the response is
+// sent with a WWW-Authenticate challenge.
+type Authenticate func(req *http.Request) (types.Session, error)
+
+// Server adapts a types.IDrive tree to the WebDAV protocol (RFC 4918,
+// class 1). Locking (class 2) is not implemented here.
+type Server struct {
+	drive   types.IDrive
+	auth    Authenticate
+	tempDir string
+}
+
+// NewServer creates a WebDAV server serving the given drive tree. tempDir
+// is used to stage cross-drive copies, see drive_util.CopyEntry.
+func NewServer(drive types.IDrive, auth Authenticate, tempDir string) *Server {
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	return &Server{drive: drive, auth: auth, tempDir: tempDir}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, e := s.auth(r); e != nil {
+		s.writeError(w, e)
+		return
+	}
+
+	reqPath := utils.CleanPath(r.URL.Path)
+
+	var e error
+	switch r.Method {
+	case http.MethodOptions:
+		s.handleOptions(w)
+	case http.MethodHead:
+		e = s.handleGet(w, r, reqPath, true)
+	case http.MethodGet:
+		e = s.handleGet(w, r, reqPath, false)
+	case "PROPFIND":
+		e = s.handlePropfind(w, r, reqPath)
+	case http.MethodPut:
+		e = s.handlePut(w, r, reqPath)
+	case "MKCOL":
+		e = s.handleMkcol(w, reqPath)
+	case http.MethodDelete:
+		e = s.handleDelete(w, reqPath)
+	case "COPY":
+		e = s.handleCopyOrMove(w, r, reqPath, false)
+	case "MOVE":
+		e = s.handleCopyOrMove(w, r, reqPath, true)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if e != nil {
+		s.writeError(w, e)
+	}
+}
+
+func (s *Server) handleOptions(w http.ResponseWriter) {
+	w.Header().Set("DAV", "1")
+	w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, MKCOL, PROPFIND, COPY, MOVE")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request, reqPath string, headOnly bool) error {
+	entry, e := s.drive.Get(reqPath)
+	if e != nil {
+		return e
+	}
+	if entry.Type().IsDir() {
+		return err.NewNotAllowedMessageError(i18n.T("drive.webdav.not_a_file", reqPath))
+	}
+	content, ok := entry.(types.IContent)
+	if !ok {
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_not_readable", reqPath))
+	}
+	if headOnly {
+		r.Method = http.MethodHead
+	}
+	return drive_util.DownloadIContent(content, w, r, false)
+}
+
+func (s *Server) handlePut(w http.ResponseWriter, r *http.Request, reqPath string) error {
+	size := r.ContentLength
+	entry, e := s.drive.Save(reqPath, size, true, r.Body, task.DummyContext())
+	if e != nil {
+		return e
+	}
+	w.Header().Set("ETag", entryETag(entry))
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (s *Server) handleMkcol(w http.ResponseWriter, reqPath string) error {
+	if _, e := s.drive.MakeDir(reqPath); e != nil {
+		return e
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, reqPath string) error {
+	if e := s.drive.Delete(reqPath, task.DummyContext()); e != nil {
+		return e
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) handleCopyOrMove(w http.ResponseWriter, r *http.Request, fromPath string, move bool) error {
+	to, e := destinationPath(r)
+	if e != nil {
+		return e
+	}
+	override := r.Header.Get("Overwrite") != "F"
+
+	from, e := s.drive.Get(fromPath)
+	if e != nil {
+		return e
+	}
+
+	ctx := task.DummyContext()
+
+	// Prefer the drive's own Copy/Move: when source and destination are the
+	// same backend (e.g. both on one WebDAVDrive), it can do this
+	// server-side in a single request (see IServerSideCopier) instead of
+	// drive_util.CopyAll reading the whole file down and back up again.
+	if move {
+		_, e = s.drive.Move(from, to, override, ctx)
+	} else {
+		_, e = s.drive.Copy(from, to, override, ctx)
+	}
+	if err.IsUnsupportedError(e) {
+		e = drive_util.CopyAll(from, s.drive, to, override, ctx,
+			func(from types.IEntry, driveTo types.IDrive, to string, ctx types.TaskCtx) error {
+				return drive_util.CopyEntry(from, driveTo, to, override, ctx, s.tempDir)
+			}, nil)
+		if e == nil && move {
+			e = s.drive.Delete(fromPath, ctx)
+		}
+	}
+	if e != nil {
+		return e
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func destinationPath(r *http.Request) (string, error) {
+	dest := r.Header.Get("Destination")
+	if dest == "" {
+		return "", err.NewBadRequestError(i18n.T("drive.webdav.missing_destination"))
+	}
+	u, e := url.Parse(dest)
+	if e != nil {
+		return "", err.NewBadRequestError(i18n.T("drive.webdav.missing_destination"))
+	}
+	return utils.CleanPath(u.Path), nil
+}
+
+func (s *Server) writeError(w http.ResponseWriter, e error) {
+	status := http.StatusInternalServerError
+	switch {
+	case err.IsNotFoundError(e):
+		status = http.StatusNotFound
+	case err.IsNotAllowedError(e):
+		status = http.StatusForbidden
+	case err.IsUnauthorizedError(e):
+		w.Header().Set("WWW-Authenticate", `Basic realm="go-drive"`)
+		status = http.StatusUnauthorized
+	case err.IsBadRequestError(e):
+		status = http.StatusBadRequest
+	case err.IsLockedError(e):
+		status = http.StatusLocked
+	}
+	http.Error(w, e.Error(), status)
+}
@@ -0,0 +1,35 @@
+package webdav_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDestinationPath(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/a/b", nil)
+	r.Header.Set("Destination", "http://example.com/dav/c%20d/e.txt")
+
+	path, e := destinationPath(r)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if path != "/dav/c d/e.txt" {
+		t.Errorf("destinationPath() = %q, want %q", path, "/dav/c d/e.txt")
+	}
+}
+
+func TestDestinationPathMissing(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/a/b", nil)
+	if _, e := destinationPath(r); e == nil {
+		t.Error("expected error for missing Destination header, got nil")
+	}
+}
+
+func TestDestinationPathMalformed(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/a/b", nil)
+	r.Header.Set("Destination", "://bad-url")
+	if _, e := destinationPath(r); e == nil {
+		t.Error("expected error for malformed Destination header, got nil")
+	}
+}